@@ -18,17 +18,26 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/keytransparency/core/adminserver"
+	"github.com/google/keytransparency/core/mutator"
 	"github.com/google/keytransparency/core/sequencer"
+	"github.com/google/keytransparency/impl/keyprovider/vault"
+	etcdqueue "github.com/google/keytransparency/impl/mutationqueue/etcd"
 	"github.com/google/keytransparency/impl/sql/domain"
 	"github.com/google/keytransparency/impl/sql/engine"
 	"github.com/google/keytransparency/impl/sql/mutationstorage"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/trillian"
+	vaultapi "github.com/hashicorp/vault/api"
+	clientv3 "go.etcd.io/etcd/clientv3"
 	"google.golang.org/grpc"
 
 	"github.com/google/trillian/crypto/keys/der"
@@ -44,8 +53,55 @@ var (
 	logURL    = flag.String("log-url", "", "URL of Trillian Log Server for Signed Map Heads")
 	refresh   = flag.Duration("domain-refresh", 5*time.Second, "Time to detect new domain")
 	batchSize = flag.Int("batch-size", 100, "Maximum number of mutations to process per map revision")
+
+	// Vault connection info. When vaultAddr is set, domain keys are
+	// generated and held inside Vault's Transit engine instead of as
+	// local DER bytes on the signer host.
+	vaultAddr  = flag.String("vault-addr", "", "Address of the Vault server used for domain key management; local keys are used if empty")
+	vaultMount = flag.String("vault-transit-mount", "transit", "Vault Transit secrets engine mount point")
+
+	// etcd connection info. When etcdEndpoints is set, pending mutations
+	// are queued in etcd and sequencer leadership per domain is decided
+	// by an etcd election, instead of reading the SQL mutation table
+	// directly; this lets multiple signer replicas run for HA. Each
+	// domain's own MinInterval/MaxInterval (set at CreateDomain time)
+	// governs its watch loop, so there is no global interval flag here.
+	etcdEndpoints = flag.String("etcd-endpoints", "", "Comma-separated list of etcd endpoints used for the mutation queue; the SQL mutation table is used directly if empty")
 )
 
+// newKeyGen returns the keygen closure passed to adminserver.New: a
+// Vault-backed KeyProvider when vault-addr is configured, or the legacy
+// local DER keygen otherwise.
+func newKeyGen() (func(ctx context.Context, spec *keyspb.Specification) (proto.Message, error), error) {
+	if *vaultAddr == "" {
+		return func(ctx context.Context, spec *keyspb.Specification) (proto.Message, error) {
+			return der.NewProtoFromSpec(spec)
+		}, nil
+	}
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: *vaultAddr})
+	if err != nil {
+		return nil, fmt.Errorf("vault.NewClient(%v): %v", *vaultAddr, err)
+	}
+	provider := vault.New(client, *vaultMount)
+	return adminserver.KeyGenFunc(provider), nil
+}
+
+// newMutationQueue returns the MutationQueue passed to sequencer.New: an
+// etcd-backed queue (and, with it, a sequencer.QueueWatcher) when
+// etcd-endpoints is configured, or sqlQueue directly otherwise.
+func newMutationQueue(sqlQueue mutator.MutationQueue) (mutator.MutationQueue, error) {
+	if *etcdEndpoints == "" {
+		return sqlQueue, nil
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: strings.Split(*etcdEndpoints, ","),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("etcd.New(%v): %v", *etcdEndpoints, err)
+	}
+	return etcdqueue.New(client), nil
+}
+
 func openDB() *sql.DB {
 	db, err := sql.Open(engine.DriverName, *serverDBPath)
 	if err != nil {
@@ -103,15 +159,21 @@ func main() {
 	}
 	defer stop()
 
+	queue, err := newMutationQueue(mutations)
+	if err != nil {
+		glog.Exitf("newMutationQueue(): %v", err)
+	}
+
 	signer := sequencer.New(
 		sequencerClient,
 		mapAdmin,
 		domainStorage,
-		mutations,
+		queue,
 		*batchSize)
 
-	keygen := func(ctx context.Context, spec *keyspb.Specification) (proto.Message, error) {
-		return der.NewProtoFromSpec(spec)
+	keygen, err := newKeyGen()
+	if err != nil {
+		glog.Exitf("newKeyGen(): %v", err)
 	}
 	adminServer := adminserver.New(tlog, tmap, logAdmin, mapAdmin, domainStorage, keygen)
 	glog.Infof("Signer starting")
@@ -121,10 +183,53 @@ func main() {
 
 	cctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	if err := sequencer.PeriodicallyRun(ctx, time.Tick(*refresh),
+	if watcher, ok := queue.(sequencer.ElectableQueue); ok {
+		// The etcd queue can tell us when mutations arrive, so run in an
+		// event-driven fashion: one goroutine per domain, each
+		// campaigning for that domain's etcd election so that only the
+		// replica that wins it ever calls RunBatch for that domain.
+		if err := runWatchedDomains(ctx, domainStorage, watcher, signer); err != nil {
+			glog.Errorf("runWatchedDomains(): %v", err)
+		}
+	} else if err := sequencer.PeriodicallyRun(ctx, time.Tick(*refresh),
 		signer.RunBatchForAllDomains); err != nil {
 		glog.Errorf("PeriodicallyRun(RunBatchForAllDomains): %v", err)
 	}
 	httpServer.Shutdown(cctx)
 	glog.Errorf("Signer exiting")
 }
+
+// runWatchedDomains lists every configured domain and runs
+// sequencer.WatchAndRun for each concurrently, sourcing MinInterval and
+// MaxInterval from the domain's own config rather than a global flag.
+// It returns once ctx is done and every per-domain goroutine has resigned
+// its election.
+func runWatchedDomains(ctx context.Context, domainStorage *domain.Storage, queue sequencer.ElectableQueue, signer *sequencer.Signer) error {
+	domains, err := domainStorage.List(ctx)
+	if err != nil {
+		return fmt.Errorf("domainStorage.List(): %v", err)
+	}
+	var wg sync.WaitGroup
+	for _, d := range domains {
+		minInterval, err := ptypes.Duration(d.MinInterval)
+		if err != nil {
+			glog.Errorf("ptypes.Duration(%v.MinInterval): %v", d.DomainId, err)
+			continue
+		}
+		maxInterval, err := ptypes.Duration(d.MaxInterval)
+		if err != nil {
+			glog.Errorf("ptypes.Duration(%v.MaxInterval): %v", d.DomainId, err)
+			continue
+		}
+		wg.Add(1)
+		go func(domainID string, minInterval, maxInterval time.Duration) {
+			defer wg.Done()
+			if err := sequencer.WatchAndRun(ctx, queue, domainID, minInterval, maxInterval,
+				signer.RunBatch); err != nil {
+				glog.Errorf("WatchAndRun(%v): %v", domainID, err)
+			}
+		}(d.DomainId, minInterval, maxInterval)
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminserver
+
+import (
+	"context"
+	"crypto"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian/crypto/keyspb"
+)
+
+// KeyProvider generates and uses domain signing keys without ever handing
+// their private material back to the caller. Implementations may delegate
+// to an external secret store (e.g. Vault, a cloud KMS) so that VRF, log,
+// and map private keys are never held in the clear on the signer host.
+//
+// The proto.Message returned by Generate is an opaque key reference, of a
+// type specific to the implementation (e.g. impl/keyprovider/vault's
+// KeyHandle), that can be stored directly in the CreateDomainRequest
+// VrfPrivateKey/LogPrivateKey/MapPrivateKey fields. adminserver itself
+// never calls Sign or Public: implementations are expected to register a
+// github.com/google/trillian/crypto/keys.ProtoHandler for their own
+// reference type in an init() func, so that wherever Trillian resolves a
+// stored key proto into a crypto.Signer it calls back into Sign/Public
+// rather than ASN.1-parsing the proto as a DER private key.
+type KeyProvider interface {
+	// Generate creates a new private key matching spec in the external
+	// store and returns an opaque reference to it.
+	Generate(ctx context.Context, spec *keyspb.Specification) (proto.Message, error)
+	// Sign returns the signature over digest produced by the key
+	// identified by keyRef.
+	Sign(ctx context.Context, keyRef string, digest []byte) ([]byte, error)
+	// Public returns the public key material for keyRef.
+	Public(ctx context.Context, keyRef string) (crypto.PublicKey, error)
+}
+
+// KeyGenFunc adapts a KeyProvider's Generate method to the keygen closure
+// signature that New already accepts, so a KeyProvider can be used
+// anywhere a local, in-process keygen function is accepted today.
+func KeyGenFunc(p KeyProvider) func(ctx context.Context, spec *keyspb.Specification) (proto.Message, error) {
+	return p.Generate
+}
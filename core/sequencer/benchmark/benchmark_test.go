@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// batchSizes are the representative map-revision sizes bin/run-benchmarks.sh
+// exercises; override with -bench='BenchmarkRunBatch/mutations=1000$' to
+// run just one.
+var batchSizes = []int{100, 1000, 10000}
+
+// BenchmarkRunBatch pre-loads each size in batchSizes worth of synthetic
+// mutations into a fresh Harness, then measures signer.RunBatch over
+// them, reporting mutations/sec alongside the usual ns/op.
+//
+// RunBatch only has real work to do once per pre-load, so run this with
+// -benchtime=1x; later iterations still execute but measure the
+// steady-state cost of an empty batch, not the batch size under test.
+func BenchmarkRunBatch(b *testing.B) {
+	for _, n := range batchSizes {
+		n := n
+		b.Run(fmt.Sprintf("mutations=%d", n), func(b *testing.B) {
+			ctx := context.Background()
+			h, err := New(ctx, Config{BatchSize: n})
+			if err != nil {
+				b.Fatalf("New(): %v", err)
+			}
+			defer h.Close()
+			if err := h.Preload(ctx, n); err != nil {
+				b.Fatalf("Preload(%d): %v", n, err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d, err := h.RunBatch(ctx)
+				if err != nil {
+					b.Fatalf("RunBatch(): %v", err)
+				}
+				b.ReportMetric(float64(n)/d.Seconds(), "mutations/sec")
+			}
+			for _, name := range []string{"map_leaves_written", "log_entries_appended"} {
+				samples := h.Metrics.Samples(name)
+				if len(samples) == 0 {
+					continue
+				}
+				total := 0.0
+				for _, s := range samples {
+					total += s
+				}
+				b.ReportMetric(total, name)
+			}
+		})
+	}
+}
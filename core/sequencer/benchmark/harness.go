@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchmark
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/google/keytransparency/core/sequencer"
+	"github.com/google/keytransparency/impl/integration"
+
+	pb "github.com/google/keytransparency/core/api/v1/keytransparency_go_proto"
+)
+
+// Config parameterizes a benchmark run.
+type Config struct {
+	// BatchSize is the maximum number of mutations RunBatch processes
+	// per map revision, mirroring cmd/keytransparency-sequencer's
+	// -batch-size flag.
+	BatchSize int
+	// KeySize is the size in bytes of each synthetic mutation's key
+	// material.
+	KeySize int
+}
+
+// Harness wires together an impl/integration.Env, whose sequencer server
+// records into a CapturingMetricFactory, and a sequencer.Signer on top of
+// it, so benchmarks can focus on measuring RunBatch instead of
+// re-deriving test setup.
+type Harness struct {
+	Env     *integration.Env
+	Signer  *sequencer.Signer
+	Metrics *CapturingMetricFactory
+	keySize int
+}
+
+// New spins up a fresh in-memory Trillian log/map and SQLite mutation
+// store via impl/integration.NewEnv, and a Signer configured with
+// cfg.BatchSize on top of it.
+func New(ctx context.Context, cfg Config) (*Harness, error) {
+	metrics := NewCapturingMetricFactory()
+	env, err := integration.NewEnvWithMetrics(ctx, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("benchmark: NewEnvWithMetrics(): %v", err)
+	}
+	signer := sequencer.New(
+		env.Sequencer,
+		env.MapAdmin,
+		env.DomainStorage,
+		env.Mutations,
+		cfg.BatchSize,
+	)
+	keySize := cfg.KeySize
+	if keySize == 0 {
+		keySize = 32
+	}
+	return &Harness{Env: env, Signer: signer, Metrics: metrics, keySize: keySize}, nil
+}
+
+// Close releases every resource held by the underlying environment.
+func (h *Harness) Close() {
+	h.Env.Close()
+}
+
+// Preload sends n synthetic mutations into the domain's mutation queue so
+// the next RunBatch call has real work to do. The synthetic entries carry
+// random key material of the harness's configured KeySize and stand in
+// for a real client's signed profile update.
+func (h *Harness) Preload(ctx context.Context, n int) error {
+	domainID := h.Env.Domain.DomainId
+	for i := 0; i < n; i++ {
+		update, err := h.syntheticUpdate(fmt.Sprintf("benchmark-user-%d", i))
+		if err != nil {
+			return err
+		}
+		if err := h.Env.Mutations.Send(ctx, domainID, update); err != nil {
+			return fmt.Errorf("benchmark: Send(%v): %v", i, err)
+		}
+	}
+	return nil
+}
+
+// syntheticUpdate builds a placeholder EntryUpdate carrying userID and
+// keySize bytes of random key material. It is not signed the way a real
+// client.Update call would sign one; RunBatch only needs something of the
+// right shape and size to measure map-leaf write and log-append cost.
+func (h *Harness) syntheticUpdate(userID string) (*pb.EntryUpdate, error) {
+	key := make([]byte, h.keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("benchmark: rand.Read(): %v", err)
+	}
+	return &pb.EntryUpdate{
+		Mutation: &pb.Entry{
+			Index:      []byte(userID),
+			Commitment: key,
+		},
+	}, nil
+}
+
+// RunBatch times a single call to Signer.RunBatch for the harness's
+// domain.
+func (h *Harness) RunBatch(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := h.Signer.RunBatch(ctx, h.Env.Domain.DomainId)
+	return time.Since(start), err
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// QueueWatcher is implemented by a MutationQueue backend that can notify
+// callers when new mutations may be pending for a domain, so that a
+// signer replica does not have to poll on a fixed interval to stay
+// responsive.
+type QueueWatcher interface {
+	// Watch sends on the returned channel whenever domainID may have
+	// pending mutations and closes it when ctx is done. Implementations
+	// may coalesce bursts of arrivals into a single signal.
+	Watch(ctx context.Context, domainID string) <-chan struct{}
+}
+
+// ElectableQueue is a QueueWatcher that also elects a single active
+// consumer per domain, so that WatchAndRun can be called concurrently by
+// multiple signer replicas for the same domainID with only the elected
+// replica ever calling runBatch. impl/mutationqueue/etcd.Queue implements
+// this.
+type ElectableQueue interface {
+	QueueWatcher
+	// Campaign blocks until this replica is elected the active
+	// sequencer for domainID.
+	Campaign(ctx context.Context, domainID string) error
+	// Resign gives up leadership of domainID, if held, so another
+	// replica can take over.
+	Resign(ctx context.Context, domainID string) error
+}
+
+// WatchAndRun is an event-driven counterpart to PeriodicallyRun, scoped
+// to a single domain. It first campaigns for leadership of domainID on
+// queue - so that only one of potentially many signer replicas watching
+// the same queue is ever active for that domain - then runs runBatch as
+// mutations arrive (per QueueWatcher.Watch) subject to minInterval (so a
+// burst of arrivals doesn't thrash the map) and maxInterval (so a
+// revision is still cut periodically even when the queue is idle),
+// matching domainID's own MinInterval/MaxInterval config. Once won,
+// leadership is resigned before WatchAndRun returns, whether that is
+// because ctx was canceled or because the Watch channel was closed. If
+// Campaign itself fails, WatchAndRun returns its error without calling
+// Resign.
+func WatchAndRun(ctx context.Context, queue ElectableQueue, domainID string, minInterval, maxInterval time.Duration, runBatch func(ctx context.Context, domainID string) error) error {
+	if err := queue.Campaign(ctx, domainID); err != nil {
+		return fmt.Errorf("sequencer: Campaign(%v): %v", domainID, err)
+	}
+	defer func() {
+		rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := queue.Resign(rctx, domainID); err != nil {
+			glog.Errorf("sequencer: Resign(%v): %v", domainID, err)
+		}
+	}()
+
+	notify := queue.Watch(ctx, domainID)
+	var lastRun time.Time
+	maxTimer := time.NewTimer(maxInterval)
+	defer maxTimer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-notify:
+			if !ok {
+				return nil
+			}
+			if wait := minInterval - time.Since(lastRun); wait > 0 {
+				time.Sleep(wait)
+			}
+		case <-maxTimer.C:
+		}
+
+		if err := runBatch(ctx, domainID); err != nil {
+			glog.Errorf("sequencer: WatchAndRun(%v): runBatch(): %v", domainID, err)
+		}
+		lastRun = time.Now()
+
+		if !maxTimer.Stop() {
+			select {
+			case <-maxTimer.C:
+			default:
+			}
+		}
+		maxTimer.Reset(maxInterval)
+	}
+}
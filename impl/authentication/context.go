@@ -0,0 +1,46 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authentication
+
+import "context"
+
+type contextKey string
+
+const (
+	subjectKey contextKey = "subject"
+	groupsKey  contextKey = "groups"
+)
+
+// NewContext returns a copy of ctx carrying the authenticated subject and
+// any group claims presented alongside it, so that AuthzFunc
+// implementations can later read back who made the request.
+func NewContext(ctx context.Context, subject string, groups []string) context.Context {
+	ctx = context.WithValue(ctx, subjectKey, subject)
+	return context.WithValue(ctx, groupsKey, groups)
+}
+
+// SubjectFromContext returns the authenticated subject stored by
+// NewContext, if any.
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectKey).(string)
+	return subject, ok
+}
+
+// GroupsFromContext returns the group claims stored by NewContext, if
+// any.
+func GroupsFromContext(ctx context.Context) ([]string, bool) {
+	groups, ok := ctx.Value(groupsKey).([]string)
+	return groups, ok
+}
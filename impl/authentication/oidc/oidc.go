@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidc authenticates gRPC requests against a configured OIDC
+// issuer in place of authentication.FakeAuthFunc, mapping a claim in the
+// bearer token to the KT user_id used by UpdateEntry.
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goidc "github.com/coreos/go-oidc"
+	"github.com/golang/glog"
+	"github.com/grpc-ecosystem/go-grpc-middleware/auth"
+
+	"github.com/google/keytransparency/impl/authentication"
+)
+
+// Config configures an Authenticator.
+type Config struct {
+	// Issuer is the OIDC issuer URL used to fetch the discovery document
+	// and JWKS.
+	Issuer string
+	// ClientID is the expected `aud` claim of incoming tokens.
+	ClientID string
+	// UserIDClaim names the claim mapped to the KT user_id. Defaults to
+	// "email", falling back to "sub" when the claim is absent.
+	UserIDClaim string
+	// GroupsClaim names the claim holding the group memberships used by
+	// authorization.DomainAuthz allow-lists. Optional.
+	GroupsClaim string
+	// ClockSkew is how long past its `exp` a token is still accepted: the
+	// verifier's clock is set back by ClockSkew. Defaults to 2 minutes.
+	ClockSkew time.Duration
+}
+
+// Authenticator validates bearer JWTs against a configured OIDC issuer.
+type Authenticator struct {
+	verifier    *goidc.IDTokenVerifier
+	userIDClaim string
+	groupsClaim string
+}
+
+// New creates an Authenticator that fetches cfg.Issuer's discovery
+// document and refreshes its JWKS as needed.
+func New(ctx context.Context, cfg Config) (*Authenticator, error) {
+	provider, err := goidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: NewProvider(%v): %v", cfg.Issuer, err)
+	}
+	skew := cfg.ClockSkew
+	if skew == 0 {
+		skew = 2 * time.Minute
+	}
+	userIDClaim := cfg.UserIDClaim
+	if userIDClaim == "" {
+		userIDClaim = "email"
+	}
+	return &Authenticator{
+		verifier: provider.Verifier(&goidc.Config{
+			ClientID:             cfg.ClientID,
+			SupportedSigningAlgs: []string{goidc.RS256, goidc.ES256},
+			Now:                  func() time.Time { return time.Now().Add(-skew) },
+		}),
+		userIDClaim: userIDClaim,
+		groupsClaim: cfg.GroupsClaim,
+	}, nil
+}
+
+// AuthFunc implements the grpc_auth.AuthFunc signature expected by
+// authorization.AuthPair.AuthnFunc: it extracts the bearer token from
+// ctx's incoming metadata, verifies it against the configured issuer, and
+// stashes the mapped subject and any group claims on the returned
+// context for later use by an authorization.AuthzFunc.
+func (a *Authenticator) AuthFunc(ctx context.Context) (context.Context, error) {
+	token, err := grpc_auth.AuthFromMD(ctx, "Bearer")
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: Verify(): %v", err)
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: Claims(): %v", err)
+	}
+	userID, _ := claims[a.userIDClaim].(string)
+	if userID == "" {
+		userID = idToken.Subject
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("oidc: token has neither %q nor a subject claim", a.userIDClaim)
+	}
+	var groups []string
+	if a.groupsClaim != "" {
+		if raw, ok := claims[a.groupsClaim].([]interface{}); ok {
+			for _, g := range raw {
+				if s, ok := g.(string); ok {
+					groups = append(groups, s)
+				}
+			}
+		}
+	}
+	glog.V(5).Infof("oidc: authenticated subject %q with groups %v", userID, groups)
+	return authentication.NewContext(ctx, userID, groups), nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authorization
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/keytransparency/impl/authentication"
+)
+
+// fakeUpdateEntryRequest stands in for a generated UpdateEntryRequest
+// proto; it only needs to implement proto.Message and GetDomainId for
+// DomainAuthz.Authorize to route it.
+type fakeUpdateEntryRequest struct {
+	domainID string
+}
+
+func (r *fakeUpdateEntryRequest) Reset()         {}
+func (r *fakeUpdateEntryRequest) String() string { return "fakeUpdateEntryRequest" }
+func (r *fakeUpdateEntryRequest) ProtoMessage()  {}
+func (r *fakeUpdateEntryRequest) GetDomainId() string {
+	return r.domainID
+}
+
+// fakeRuleStore is an in-memory DomainRuleStore that stands in for SQL
+// domain storage; an empty one simulates a fresh database (no rules
+// configured for any domain).
+type fakeRuleStore map[string]DomainRules
+
+func (s fakeRuleStore) AuthzRules(ctx context.Context, domainID string) (DomainRules, error) {
+	return s[domainID], nil
+}
+
+// TestDomainAuthzAuthorizeFreshDomain verifies that a domain with no
+// rules configured - the state of every domain on a freshly created
+// database - is treated as unrestricted, mirroring the no-op
+// &AuthzPolicy{} this replaced as the production default.
+func TestDomainAuthzAuthorizeFreshDomain(t *testing.T) {
+	authz := NewDomainAuthz(fakeRuleStore{})
+	req := &fakeUpdateEntryRequest{domainID: "fresh-domain"}
+	if err := authz.Authorize(context.Background(), "alice", req); err != nil {
+		t.Errorf("Authorize() on a domain with no configured rules = %v, want nil", err)
+	}
+}
+
+// TestDomainAuthzAuthorizeRequireSubjectMatch verifies that
+// RequireSubjectMatch rejects a mutation whose target user doesn't match
+// the authenticated subject, and allows one that does.
+func TestDomainAuthzAuthorizeRequireSubjectMatch(t *testing.T) {
+	store := fakeRuleStore{
+		"strict-domain": DomainRules{RequireSubjectMatch: true},
+	}
+	authz := NewDomainAuthz(store)
+	req := &fakeUpdateEntryRequest{domainID: "strict-domain"}
+
+	ctx := authentication.NewContext(context.Background(), "alice", nil)
+	if err := authz.Authorize(ctx, "alice", req); err != nil {
+		t.Errorf("Authorize() for the matching subject = %v, want nil", err)
+	}
+	if err := authz.Authorize(ctx, "bob", req); err == nil {
+		t.Errorf("Authorize() for a mismatched subject = nil, want an error")
+	}
+}
+
+// TestDomainAuthzAuthorizeAllowedGroups verifies that AllowedGroups
+// rejects a subject with no matching group claim, and allows one that
+// has a matching group.
+func TestDomainAuthzAuthorizeAllowedGroups(t *testing.T) {
+	store := fakeRuleStore{
+		"group-domain": DomainRules{AllowedGroups: []string{"admins"}},
+	}
+	authz := NewDomainAuthz(store)
+	req := &fakeUpdateEntryRequest{domainID: "group-domain"}
+
+	allowed := authentication.NewContext(context.Background(), "alice", []string{"admins", "eng"})
+	if err := authz.Authorize(allowed, "alice", req); err != nil {
+		t.Errorf("Authorize() for a subject in an allowed group = %v, want nil", err)
+	}
+
+	denied := authentication.NewContext(context.Background(), "alice", []string{"eng"})
+	if err := authz.Authorize(denied, "alice", req); err == nil {
+		t.Errorf("Authorize() for a subject in no allowed group = nil, want an error")
+	}
+}
@@ -27,7 +27,9 @@ import (
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/kr/pretty"
+	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/oauth"
 
 	"github.com/google/keytransparency/core/adminserver"
 	"github.com/google/keytransparency/core/client"
@@ -36,10 +38,11 @@ import (
 	"github.com/google/keytransparency/core/mutator"
 	"github.com/google/keytransparency/core/mutator/entry"
 	"github.com/google/keytransparency/core/sequencer"
-	"github.com/google/keytransparency/impl/authentication"
+	"github.com/google/keytransparency/impl/authentication/oidc"
 	"github.com/google/keytransparency/impl/authorization"
 	"github.com/google/keytransparency/impl/sql/domain"
 	"github.com/google/keytransparency/impl/sql/mutationstorage"
+	"github.com/google/trillian"
 	"github.com/google/trillian/crypto/keys/der"
 	"github.com/google/trillian/crypto/keyspb"
 	"github.com/google/trillian/monitoring"
@@ -72,6 +75,10 @@ EeNeHYEb/T2jBFH4eYg4iSN7D/VYaJxJRA==
 -----END EC PRIVATE KEY-----`
 )
 
+// testOIDCClientID is the audience NewEnv's test OIDC issuer mints
+// tokens for and the authenticator it wires in expects.
+const testOIDCClientID = "keytransparency-integration-test"
+
 // Listen opens a random local port and listens on it.
 func Listen() (string, net.Listener, error) {
 	lis, err := net.Listen("tcp", "localhost:0")
@@ -96,6 +103,15 @@ type Env struct {
 	grpcCC        *grpc.ClientConn
 	db            *sql.DB
 	stopSequencer func()
+	oidcIssuer    *testOIDCIssuer
+
+	// DomainStorage, Mutations, and MapAdmin are exported so that callers
+	// building their own sequencer.Signer on top of this environment
+	// (e.g. core/sequencer/benchmark) don't have to duplicate the setup
+	// NewEnv already does.
+	DomainStorage *domain.Storage
+	Mutations     *mutationstorage.Mutations
+	MapAdmin      trillian.TrillianAdminClient
 }
 
 func vrfKeyGen(ctx context.Context, spec *keyspb.Specification) (proto.Message, error) {
@@ -114,6 +130,14 @@ func keyFromPEM(p string) *any.Any {
 
 // NewEnv sets up common resources for tests.
 func NewEnv(ctx context.Context) (*Env, error) {
+	return NewEnvWithMetrics(ctx, monitoring.InertMetricFactory{})
+}
+
+// NewEnvWithMetrics is NewEnv, but lets the caller supply the
+// monitoring.MetricFactory used by the sequencer server, so a tool like
+// core/sequencer/benchmark can capture the samples recorded while driving
+// this environment instead of discarding them.
+func NewEnvWithMetrics(ctx context.Context, metricFactory monitoring.MetricFactory) (*Env, error) {
 	timeout := 6 * time.Second
 	domainID := "integration"
 
@@ -162,8 +186,25 @@ func NewEnv(ctx context.Context) (*Env, error) {
 	if err != nil {
 		return nil, fmt.Errorf("env: Failed to create mutations object: %v", err)
 	}
-	authFunc := authentication.FakeAuthFunc
-	authz := &authorization.AuthzPolicy{}
+	// Stand up a real OIDC issuer so tests exercise the same
+	// impl/authentication/oidc.Authenticator production traffic goes
+	// through, rather than a fake credential.
+	oidcIssuer, err := newTestOIDCIssuer()
+	if err != nil {
+		return nil, fmt.Errorf("env: newTestOIDCIssuer(): %v", err)
+	}
+	oidcAuth, err := oidc.New(ctx, oidc.Config{
+		Issuer:      oidcIssuer.server.URL,
+		ClientID:    testOIDCClientID,
+		UserIDClaim: "email",
+		GroupsClaim: "groups",
+	})
+	if err != nil {
+		oidcIssuer.Close()
+		return nil, fmt.Errorf("env: oidc.New(): %v", err)
+	}
+	authFunc := oidcAuth.AuthFunc
+	authz := authorization.NewDomainAuthz(domainStorage)
 
 	queue := mutator.MutationQueue(mutations)
 	server := keyserver.New(logEnv.Log, mapEnv.Map, logEnv.Admin, mapEnv.Admin,
@@ -186,7 +227,7 @@ func NewEnv(ctx context.Context) (*Env, error) {
 		logEnv.Admin, mapEnv.Admin,
 		logEnv.Log, mapEnv.Map,
 		mutations, mutations,
-		monitoring.InertMetricFactory{},
+		metricFactory,
 	)
 
 	sequencerClient, stop, err := sequencer.RunAndConnect(ctx, sequencerServer)
@@ -220,7 +261,14 @@ func NewEnv(ctx context.Context) (*Env, error) {
 			Domain:    domainPB,
 			Timeout:   timeout,
 			CallOpts: func(userID string) []grpc.CallOption {
-				return []grpc.CallOption{grpc.PerRPCCredentials(authentication.GetFakeCredential(userID))}
+				token, err := oidcIssuer.token(testOIDCClientID, userID, nil)
+				if err != nil {
+					glog.Errorf("env: token(%v): %v", userID, err)
+				}
+				return []grpc.CallOption{grpc.PerRPCCredentials(oauth.NewOauthAccess(&oauth2.Token{
+					AccessToken: token,
+					TokenType:   "Bearer",
+				}))}
 			},
 		},
 		mapEnv:        mapEnv,
@@ -230,6 +278,10 @@ func NewEnv(ctx context.Context) (*Env, error) {
 		grpcCC:        cc,
 		db:            db,
 		stopSequencer: stop,
+		oidcIssuer:    oidcIssuer,
+		DomainStorage: domainStorage,
+		Mutations:     mutations,
+		MapAdmin:      mapEnv.Admin,
 	}, nil
 }
 
@@ -247,4 +299,5 @@ func (env *Env) Close() {
 	env.mapEnv.Close()
 	env.logEnv.Close()
 	env.db.Close()
+	env.oidcIssuer.Close()
 }
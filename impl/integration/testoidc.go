@@ -0,0 +1,137 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// testOIDCIssuer is a minimal, self-signed OIDC provider - a discovery
+// document, a JWKS endpoint, and ID tokens signed with an in-memory RSA
+// key - so NewEnv can drive UpdateEntry through a real
+// impl/authentication/oidc.Authenticator instead of
+// authentication.FakeAuthFunc.
+type testOIDCIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+const testOIDCKeyID = "integration-test-key"
+
+// newTestOIDCIssuer starts the test issuer. Callers must call Close when
+// done with it.
+func newTestOIDCIssuer() (*testOIDCIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("testoidc: GenerateKey(): %v", err)
+	}
+	issuer := &testOIDCIssuer{key: key, keyID: testOIDCKeyID}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.serveDiscovery)
+	mux.HandleFunc("/.well-known/jwks.json", issuer.serveJWKS)
+	issuer.server = httptest.NewServer(mux)
+	return issuer, nil
+}
+
+// Close shuts down the underlying HTTP test server.
+func (i *testOIDCIssuer) Close() {
+	i.server.Close()
+}
+
+func (i *testOIDCIssuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                i.server.URL,
+		"jwks_uri":                              i.server.URL + "/.well-known/jwks.json",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *testOIDCIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := i.key.PublicKey
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]interface{}{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": i.keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(encodeExponent(pub.E)),
+			},
+		},
+	})
+}
+
+// encodeExponent big-endian encodes a JWK "e" value, trimming leading
+// zero bytes as RFC 7518 requires.
+func encodeExponent(e int) []byte {
+	buf := []byte{byte(e >> 24), byte(e >> 16), byte(e >> 8), byte(e)}
+	i := 0
+	for i < len(buf)-1 && buf[i] == 0 {
+		i++
+	}
+	return buf[i:]
+}
+
+// token mints a signed JWT asserting subject as both "sub" and "email",
+// and groups as the "groups" claim, for the given audience/clientID.
+func (i *testOIDCIssuer) token(clientID, subject string, groups []string) (string, error) {
+	header := map[string]interface{}{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": i.keyID,
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   i.server.URL,
+		"sub":   subject,
+		"email": subject,
+		"aud":   clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if len(groups) > 0 {
+		claims["groups"] = groups
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("testoidc: marshal header: %v", err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("testoidc: marshal claims: %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("testoidc: SignPKCS1v15(): %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vault implements adminserver.KeyProvider backed by HashiCorp
+// Vault's Transit secrets engine, so that domain signing keys never leave
+// Vault in the clear. It also registers a crypto/keys.ProtoHandler for its
+// own KeyHandle proto, the same extensibility point der/pem/pkcs11 use in
+// Trillian, so that wherever Trillian resolves a domain's stored private
+// key proto into a crypto.Signer it transparently gets a Vault-backed
+// signer instead of one holding DER key material in memory.
+package vault
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian/crypto/keys"
+	"github.com/google/trillian/crypto/keyspb"
+	"github.com/hashicorp/vault/api"
+
+	"github.com/google/keytransparency/core/adminserver"
+)
+
+func init() {
+	keys.RegisterHandler(&KeyHandle{}, protoHandler)
+}
+
+// KeyHandle is the proto.Message Generate returns in place of a
+// keyspb.PrivateKey, and is what gets stored in CreateDomainRequest's
+// VrfPrivateKey/LogPrivateKey/MapPrivateKey fields for a Vault-backed
+// domain. Unlike keyspb.PrivateKey.Der, KeyHandle is never ASN.1-parsed
+// as private key material - it only identifies where the key lives, and
+// protoHandler is the sole place that turns it into a usable crypto.Signer.
+type KeyHandle struct {
+	// Address is the Vault server address the key was created on, so
+	// protoHandler can reach it without depending on the Provider
+	// instance that called Generate still being around.
+	Address string
+	Mount   string
+	Name    string
+}
+
+func (h *KeyHandle) Reset() { *h = KeyHandle{} }
+func (h *KeyHandle) String() string {
+	return fmt.Sprintf("vault.KeyHandle{Address: %q, Mount: %q, Name: %q}", h.Address, h.Mount, h.Name)
+}
+func (h *KeyHandle) ProtoMessage() {}
+
+// protoHandler is the crypto/keys.ProtoHandler for KeyHandle: it gives
+// Trillian's log/map signer construction a crypto.Signer that routes
+// every Sign/Public call through Vault's Transit engine, so the
+// underlying private key never leaves Vault.
+func protoHandler(ctx context.Context, pb proto.Message) (crypto.Signer, error) {
+	handle, ok := pb.(*KeyHandle)
+	if !ok {
+		return nil, fmt.Errorf("vault: RegisterHandler: unsupported key proto type %T", pb)
+	}
+	client, err := api.NewClient(&api.Config{Address: handle.Address})
+	if err != nil {
+		return nil, fmt.Errorf("vault: api.NewClient(%v): %v", handle.Address, err)
+	}
+	ref := KeyRef{Mount: handle.Mount, Name: handle.Name}
+	return &signer{provider: New(client, handle.Mount), keyRef: ref.URI()}, nil
+}
+
+// signer adapts Provider.Sign/Public to crypto.Signer.
+type signer struct {
+	provider *Provider
+	keyRef   string
+}
+
+// Public implements crypto.Signer. Its signature has no error return, so a
+// Vault outage here is reported as a nil public key; callers that need to
+// distinguish "no key" from "Vault unreachable" should call
+// Provider.Public directly instead of going through crypto.Signer.
+func (s *signer) Public() crypto.PublicKey {
+	pub, err := s.provider.Public(context.Background(), s.keyRef)
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.provider.Sign(context.Background(), s.keyRef, digest)
+}
+
+// KeyRef identifies a single Transit key within a Vault mount.
+type KeyRef struct {
+	// Mount is the Transit secrets engine mount point, e.g. "transit".
+	Mount string
+	// Name is the name of the Transit key within Mount.
+	Name string
+}
+
+// URI returns the vault:// handle used to reference this key elsewhere.
+func (k KeyRef) URI() string {
+	return fmt.Sprintf("vault://%s/keys/%s", k.Mount, k.Name)
+}
+
+// ParseKeyRef parses the vault:// handle produced by KeyRef.URI back into
+// its mount and key name, so Sign and Public can recover the Transit path
+// from the opaque reference Generate returned.
+func ParseKeyRef(uri string) (KeyRef, error) {
+	const prefix, sep = "vault://", "/keys/"
+	if !strings.HasPrefix(uri, prefix) {
+		return KeyRef{}, fmt.Errorf("vault: malformed key reference %q", uri)
+	}
+	rest := strings.TrimPrefix(uri, prefix)
+	i := strings.Index(rest, sep)
+	if i < 0 {
+		return KeyRef{}, fmt.Errorf("vault: malformed key reference %q", uri)
+	}
+	return KeyRef{Mount: rest[:i], Name: rest[i+len(sep):]}, nil
+}
+
+// Provider is a KeyProvider that generates and uses keys via a Vault
+// Transit secrets engine mount, never returning private key material to
+// the caller.
+type Provider struct {
+	client *api.Client
+	mount  string
+}
+
+var _ adminserver.KeyProvider = (*Provider)(nil)
+
+// New returns a Provider that manages keys under mount on the Vault server
+// reachable through client.
+func New(client *api.Client, mount string) *Provider {
+	return &Provider{client: client, mount: mount}
+}
+
+// transitType maps a Trillian key specification to a Vault Transit key
+// type. Only the ECDSA P-256 signing keys used by Key Transparency domains
+// are supported today.
+func transitType(spec *keyspb.Specification) (string, error) {
+	switch spec.GetParams().(type) {
+	case *keyspb.Specification_EcdsaParams:
+		switch spec.GetEcdsaParams().GetCurve() {
+		case keyspb.Specification_ECDSA_P256, keyspb.Specification_ECDSA_DEFAULT:
+			return "ecdsa-p256", nil
+		}
+	}
+	return "", fmt.Errorf("vault: unsupported key specification: %v", spec)
+}
+
+// Generate creates a new named Transit key matching spec and returns a
+// KeyHandle identifying it, in place of a DER-encoded private key. The
+// adminserver keygen hook Generate implements is shared across every
+// domain and key role (VRF, log, map), with no domain or role identifier
+// passed through it, so the Transit key name is derived from random bytes
+// rather than any caller-supplied identifier; this is what guarantees two
+// calls never collide, not the value of the name itself.
+func (p *Provider) Generate(ctx context.Context, spec *keyspb.Specification) (proto.Message, error) {
+	keyType, err := transitType(spec)
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("vault: rand.Read(): %v", err)
+	}
+	name := fmt.Sprintf("kt-%s", hex.EncodeToString(suffix))
+	logical := p.client.Logical()
+	if _, err := logical.Write(fmt.Sprintf("%s/keys/%s", p.mount, name), map[string]interface{}{
+		"type": keyType,
+	}); err != nil {
+		return nil, fmt.Errorf("vault: create key %q: %v", name, err)
+	}
+	return &KeyHandle{Address: p.client.Address(), Mount: p.mount, Name: name}, nil
+}
+
+// Sign returns the signature over digest produced by the Transit key
+// referenced by keyRef - the vault:// URI Generate returned, not a bare
+// key name - decoded from Vault's "vault:v<version>:<base64>" response.
+func (p *Provider) Sign(ctx context.Context, keyRef string, digest []byte) ([]byte, error) {
+	ref, err := ParseKeyRef(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("%s/sign/%s", ref.Mount, ref.Name)
+	secret, err := p.client.Logical().Write(path, map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(digest),
+		"prehashed": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault: sign with key %q: %v", keyRef, err)
+	}
+	sig, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: sign response for key %q missing signature", keyRef)
+	}
+	parts := strings.Split(sig, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("vault: sign response for key %q has unexpected format %q", keyRef, sig)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("vault: sign response for key %q: %v", keyRef, err)
+	}
+	return decoded, nil
+}
+
+// Public returns the current public key for the Transit key referenced by
+// keyRef - the vault:// URI Generate returned, not a bare key name -
+// parsed from the PEM-encoded public key Vault returns for version 1
+// (Generate never rotates a key, so it only ever has one version).
+func (p *Provider) Public(ctx context.Context, keyRef string) (crypto.PublicKey, error) {
+	ref, err := ParseKeyRef(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("%s/keys/%s", ref.Mount, ref.Name)
+	secret, err := p.client.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("vault: read key %q: %v", keyRef, err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("vault: key %q not found", keyRef)
+	}
+	versions, ok := secret.Data["keys"].(map[string]interface{})
+	if !ok || len(versions) == 0 {
+		return nil, fmt.Errorf("vault: key %q response missing key versions", keyRef)
+	}
+	version, ok := versions["1"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("vault: key %q missing version 1", keyRef)
+	}
+	pemStr, ok := version["public_key"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: key %q version 1 missing public_key", keyRef)
+	}
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, fmt.Errorf("vault: key %q: invalid PEM public key", keyRef)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("vault: key %q: ParsePKIXPublicKey(): %v", keyRef, err)
+	}
+	return pub, nil
+}
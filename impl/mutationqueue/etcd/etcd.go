@@ -0,0 +1,261 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package etcd implements core/mutator.MutationQueue (Send to enqueue,
+// ReadQueue to read pending mutations back) and core/sequencer.QueueWatcher
+// on top of etcd, so that a domain's pending mutations and sequencer
+// leadership can be shared across multiple signer replicas for HA, rather
+// than tying the queue to a single SQL instance.
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	clientv3 "go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+
+	pb "github.com/google/keytransparency/core/api/v1/keytransparency_go_proto"
+)
+
+const leaseTTLSeconds = 15
+
+// mutationsPrefix returns the key prefix under which domainID's pending
+// mutations are stored, e.g. "/integration/mutations/".
+func mutationsPrefix(domainID string) string {
+	return fmt.Sprintf("/%s/mutations/", domainID)
+}
+
+func electionPrefix(domainID string) string {
+	return fmt.Sprintf("/%s/sequencer-election", domainID)
+}
+
+// Queue is a MutationQueue and QueueWatcher backed by etcd. Pending
+// mutations for a domain are stored under /<domainID>/mutations/<seq>
+// keys, where <seq> is the zero-padded UnixNano time of the Send call.
+// ReadQueue's progress through a domain is kept in memory on this Queue,
+// as the highest etcd create revision it has returned.
+type Queue struct {
+	client *clientv3.Client
+
+	mu        sync.Mutex
+	sessions  map[string]*concurrency.Session
+	elected   map[string]*concurrency.Election
+	positions map[string]int64
+}
+
+// New returns a Queue using client to talk to the etcd cluster.
+func New(client *clientv3.Client) *Queue {
+	return &Queue{
+		client:    client,
+		sessions:  make(map[string]*concurrency.Session),
+		elected:   make(map[string]*concurrency.Election),
+		positions: make(map[string]int64),
+	}
+}
+
+// Send stores update as a new pending mutation for domainID. The key
+// suffix is zero-padded so that lexicographic and sequence order agree,
+// letting RunBatchForAllDomains range over a domain's pending mutations
+// in arrival order without a separate index.
+func (q *Queue) Send(ctx context.Context, domainID string, update *pb.EntryUpdate) error {
+	val, err := proto.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("etcd: Marshal(EntryUpdate): %v", err)
+	}
+	key := fmt.Sprintf("%s%020d", mutationsPrefix(domainID), time.Now().UnixNano())
+	if _, err := q.client.Put(ctx, key, string(val)); err != nil {
+		return fmt.Errorf("etcd: Send(%v): put %v: %v", domainID, key, err)
+	}
+	return nil
+}
+
+// ReadQueue returns up to maxMutations of domainID's pending mutations,
+// in arrival order, that have not already been returned by a previous
+// ReadQueue call for domainID on this Queue. This is the read half of the
+// MutationQueue Send writes into: etcd's key space has no destructive
+// dequeue primitive the way an ack/delete-based queue would, so progress
+// is tracked as the highest create revision returned so far, scoped to
+// this Queue instance, rather than by removing keys. RunBatch calls this
+// to get the batch of mutations it applies to the map.
+func (q *Queue) ReadQueue(ctx context.Context, domainID string, maxMutations int) ([]*pb.EntryUpdate, error) {
+	q.mu.Lock()
+	from := q.positions[domainID]
+	q.mu.Unlock()
+
+	opts := []clientv3.OpOption{
+		clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		clientv3.WithLimit(int64(maxMutations)),
+	}
+	if from > 0 {
+		opts = append(opts, clientv3.WithMinCreateRev(from+1))
+	}
+	resp, err := q.client.Get(ctx, mutationsPrefix(domainID), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("etcd: ReadQueue(%v): %v", domainID, err)
+	}
+
+	updates := make([]*pb.EntryUpdate, 0, len(resp.Kvs))
+	var maxRev int64
+	for _, kv := range resp.Kvs {
+		var update pb.EntryUpdate
+		if err := proto.Unmarshal(kv.Value, &update); err != nil {
+			return nil, fmt.Errorf("etcd: ReadQueue(%v): Unmarshal(%s): %v", domainID, kv.Key, err)
+		}
+		updates = append(updates, &update)
+		if kv.CreateRevision > maxRev {
+			maxRev = kv.CreateRevision
+		}
+	}
+	if maxRev > 0 {
+		q.mu.Lock()
+		q.positions[domainID] = maxRev
+		q.mu.Unlock()
+	}
+	return updates, nil
+}
+
+// Watch implements sequencer.QueueWatcher, scoped to domainID: it
+// forwards a coalesced notification for each batch of puts under
+// domainID's mutation prefix, but only while this replica currently holds
+// domainID's sequencer election (see Campaign). A replica that is not, or
+// is no longer, the elected leader for domainID never receives
+// notifications, and once the election session it held when Watch was
+// called ends, the returned channel is closed, which makes WatchAndRun
+// return instead of going on to call runBatch on its maxInterval timer.
+func (q *Queue) Watch(ctx context.Context, domainID string) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	go q.watchDomain(ctx, domainID, out)
+	return out
+}
+
+func (q *Queue) watchDomain(ctx context.Context, domainID string, out chan<- struct{}) {
+	defer close(out)
+	q.mu.Lock()
+	session := q.sessions[domainID]
+	q.mu.Unlock()
+	var lost <-chan struct{}
+	if session != nil {
+		lost = session.Done()
+	}
+	watch := q.client.Watch(ctx, mutationsPrefix(domainID), clientv3.WithPrefix())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			// This replica's election session ended, so it is no longer
+			// the active sequencer for domainID.
+			return
+		case resp, ok := <-watch:
+			if !ok {
+				return
+			}
+			if resp.Err() != nil {
+				glog.Errorf("etcd: Watch(%v): %v", domainID, resp.Err())
+				continue
+			}
+			if !q.isLeader(domainID) {
+				// Leadership was lost (e.g. the lease expired after a
+				// crash); the new leader's own Watch call will pick up
+				// these puts, so this replica stays quiet.
+				continue
+			}
+			select {
+			case out <- struct{}{}:
+			default:
+				// A notification is already pending; WatchAndRun will
+				// pick up everything written so far on its next run.
+			}
+		}
+	}
+}
+
+// isLeader reports whether this replica currently holds domainID's
+// sequencer election.
+func (q *Queue) isLeader(domainID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.elected[domainID]
+	return ok
+}
+
+// Campaign blocks until this replica is elected the active sequencer for
+// domainID, using an etcd lease so a crashed replica's leadership is
+// automatically released after leaseTTLSeconds.
+func (q *Queue) Campaign(ctx context.Context, domainID string) error {
+	session, err := concurrency.NewSession(q.client, concurrency.WithTTL(leaseTTLSeconds))
+	if err != nil {
+		return fmt.Errorf("etcd: NewSession(): %v", err)
+	}
+	election := concurrency.NewElection(session, electionPrefix(domainID))
+	if err := election.Campaign(ctx, domainID); err != nil {
+		session.Close()
+		return fmt.Errorf("etcd: Campaign(%v): %v", domainID, err)
+	}
+	q.mu.Lock()
+	q.sessions[domainID] = session
+	q.elected[domainID] = election
+	q.mu.Unlock()
+	glog.Infof("etcd: elected active sequencer for domain %q", domainID)
+	go q.watchSessionExpiry(domainID, session)
+	return nil
+}
+
+// watchSessionExpiry clears domainID's leadership state as soon as
+// session's lease expires without an explicit Resign - e.g. because a
+// network partition kept this replica from renewing the lease in time -
+// so isLeader stops reporting stale local state as leadership once etcd
+// itself has already handed domainID's election to another replica.
+// Without this, isLeader only ever reacts to Resign, and two replicas
+// could each believe they are domainID's sole active sequencer at once:
+// exactly the split-brain the election exists to prevent.
+func (q *Queue) watchSessionExpiry(domainID string, session *concurrency.Session) {
+	<-session.Done()
+	q.mu.Lock()
+	// Guard against clearing a newer session installed by a later
+	// Campaign(domainID) call after this one was already resigned.
+	expired := q.sessions[domainID] == session
+	if expired {
+		delete(q.sessions, domainID)
+		delete(q.elected, domainID)
+	}
+	q.mu.Unlock()
+	if expired {
+		glog.Warningf("etcd: session for domain %q expired; no longer active sequencer", domainID)
+	}
+}
+
+// Resign gives up leadership of domainID's sequencer election, if held,
+// so another replica can take over.
+func (q *Queue) Resign(ctx context.Context, domainID string) error {
+	q.mu.Lock()
+	election, ok := q.elected[domainID]
+	session := q.sessions[domainID]
+	delete(q.elected, domainID)
+	delete(q.sessions, domainID)
+	q.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := election.Resign(ctx); err != nil {
+		return fmt.Errorf("etcd: Resign(%v): %v", domainID, err)
+	}
+	return session.Close()
+}
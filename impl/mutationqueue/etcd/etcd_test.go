@@ -0,0 +1,137 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/integration"
+
+	pb "github.com/google/keytransparency/core/api/v1/keytransparency_go_proto"
+)
+
+func newTestQueue(t *testing.T) (*Queue, func()) {
+	t.Helper()
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	return New(cluster.RandClient()), func() { cluster.Terminate(t) }
+}
+
+// TestSendThenReadQueue drives Send and ReadQueue against a real embedded
+// etcd cluster - the part of core/mutator.MutationQueue that was missing
+// entirely before this fix - and checks that ReadQueue only ever returns
+// each mutation once, in arrival order, across repeated calls the way
+// RunBatch's polling loop relies on.
+func TestSendThenReadQueue(t *testing.T) {
+	q, teardown := newTestQueue(t)
+	defer teardown()
+	ctx := context.Background()
+	const domainID = "domain"
+
+	want := []string{"first", "second"}
+	for _, m := range want {
+		if err := q.Send(ctx, domainID, &pb.EntryUpdate{Mutation: &pb.Entry{Index: []byte(m)}}); err != nil {
+			t.Fatalf("Send(%q): %v", m, err)
+		}
+	}
+
+	got, err := q.ReadQueue(ctx, domainID, 10)
+	if err != nil {
+		t.Fatalf("ReadQueue(): %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadQueue() returned %d mutations, want %d", len(got), len(want))
+	}
+	for i, m := range want {
+		if string(got[i].GetMutation().GetIndex()) != m {
+			t.Errorf("ReadQueue()[%d].Mutation.Index = %q, want %q", i, got[i].GetMutation().GetIndex(), m)
+		}
+	}
+
+	// Nothing new has been sent, so a second call returns nothing -
+	// mutations are not re-delivered once ReadQueue has returned them.
+	if again, err := q.ReadQueue(ctx, domainID, 10); err != nil {
+		t.Fatalf("ReadQueue() #2: %v", err)
+	} else if len(again) != 0 {
+		t.Errorf("ReadQueue() #2 returned %d mutations, want 0", len(again))
+	}
+
+	// A mutation sent after the first ReadQueue call is picked up by the
+	// next one - the path WatchAndRun's runBatch actually drives.
+	if err := q.Send(ctx, domainID, &pb.EntryUpdate{Mutation: &pb.Entry{Index: []byte("third")}}); err != nil {
+		t.Fatalf("Send(third): %v", err)
+	}
+	third, err := q.ReadQueue(ctx, domainID, 10)
+	if err != nil {
+		t.Fatalf("ReadQueue() #3: %v", err)
+	}
+	if len(third) != 1 || string(third[0].GetMutation().GetIndex()) != "third" {
+		t.Fatalf("ReadQueue() #3 = %v, want [third]", third)
+	}
+}
+
+// TestCampaignResignRoundTrip is the non-expiry half of leadership
+// tracking: Campaign grants it, Resign gives it back up.
+func TestCampaignResignRoundTrip(t *testing.T) {
+	q, teardown := newTestQueue(t)
+	defer teardown()
+	ctx := context.Background()
+	const domainID = "domain"
+
+	if err := q.Campaign(ctx, domainID); err != nil {
+		t.Fatalf("Campaign(): %v", err)
+	}
+	if !q.isLeader(domainID) {
+		t.Fatal("isLeader() = false immediately after Campaign()")
+	}
+	if err := q.Resign(ctx, domainID); err != nil {
+		t.Fatalf("Resign(): %v", err)
+	}
+	if q.isLeader(domainID) {
+		t.Error("isLeader() = true after Resign()")
+	}
+}
+
+// TestSessionExpiryClearsLeadership verifies that losing the underlying
+// lease session without an explicit Resign - simulated here by closing
+// the session directly, which fires session.Done() exactly like an
+// expired lease would - still clears leadership state, so isLeader does
+// not keep reporting stale local state once etcd has moved on. This is
+// the split-brain this fix closes: before it, only Resign ever cleared
+// q.elected, so a replica that silently lost its lease (e.g. a network
+// partition) would believe it was still the sole active sequencer.
+func TestSessionExpiryClearsLeadership(t *testing.T) {
+	q, teardown := newTestQueue(t)
+	defer teardown()
+	ctx := context.Background()
+	const domainID = "domain"
+
+	if err := q.Campaign(ctx, domainID); err != nil {
+		t.Fatalf("Campaign(): %v", err)
+	}
+	q.mu.Lock()
+	session := q.sessions[domainID]
+	q.mu.Unlock()
+	session.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for q.isLeader(domainID) {
+		if time.Now().After(deadline) {
+			t.Fatal("isLeader() still true a second after the session closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
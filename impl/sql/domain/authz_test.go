@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/keytransparency/impl/authorization"
+
+	_ "github.com/mattn/go-sqlite3" // Use sqlite database for testing.
+)
+
+// TestAuthzRulesFreshDatabase verifies that AuthzRules does not error out
+// with a missing-table error on a brand new database that has never had
+// SetAuthzRules called against it - the bug that used to surface as a
+// raw "no such table: DomainAuthz" error on every UpdateEntry call.
+func TestAuthzRulesFreshDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open(): %v", err)
+	}
+	defer db.Close()
+	storage, err := NewStorage(db)
+	if err != nil {
+		t.Fatalf("NewStorage(): %v", err)
+	}
+
+	ctx := context.Background()
+	rules, err := storage.AuthzRules(ctx, "fresh-domain")
+	if err != nil {
+		t.Fatalf("AuthzRules() on a fresh database returned an error: %v", err)
+	}
+	if rules.RequireSubjectMatch || len(rules.AllowedGroups) != 0 {
+		t.Errorf("AuthzRules() on a fresh database = %+v, want the zero value (no restrictions)", rules)
+	}
+}
+
+// TestAuthzRulesAfterSet verifies that rules written with SetAuthzRules
+// round-trip through AuthzRules.
+func TestAuthzRulesAfterSet(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open(): %v", err)
+	}
+	defer db.Close()
+	storage, err := NewStorage(db)
+	if err != nil {
+		t.Fatalf("NewStorage(): %v", err)
+	}
+
+	ctx := context.Background()
+	want := authorization.DomainRules{
+		RequireSubjectMatch: true,
+		AllowedGroups:       []string{"admins", "security"},
+	}
+	if err := storage.SetAuthzRules(ctx, "configured-domain", want); err != nil {
+		t.Fatalf("SetAuthzRules(): %v", err)
+	}
+	got, err := storage.AuthzRules(ctx, "configured-domain")
+	if err != nil {
+		t.Fatalf("AuthzRules(): %v", err)
+	}
+	if got.RequireSubjectMatch != want.RequireSubjectMatch || len(got.AllowedGroups) != len(want.AllowedGroups) {
+		t.Errorf("AuthzRules() = %+v, want %+v", got, want)
+	}
+}